@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Waiter is a single in-flight long-poll request blocked on an ETag.
+type Waiter struct {
+	Ch   chan struct{}
+	once sync.Once
+}
+
+// cancel wakes the waiter. Safe to call more than once or concurrently
+// with itself.
+func (w *Waiter) cancel() {
+	w.once.Do(func() {
+		close(w.Ch)
+	})
+}
+
+// blockEntry groups every waiter currently blocked on a given ETag along
+// with metadata about the request that first registered it.
+type blockEntry struct {
+	waiters    []*Waiter
+	createdAt  time.Time
+	backendURL string
+	method     string
+}
+
+// BlockRegistry tracks in-flight long-poll waiters keyed by ETag. Unlike
+// the old blockMap it allows any number of waiters per ETag and is safe
+// for concurrent use from multiple HTTP handlers.
+type BlockRegistry struct {
+	mu      sync.RWMutex
+	entries map[string]*blockEntry
+}
+
+// NewBlockRegistry returns an empty, ready-to-use registry.
+func NewBlockRegistry() *BlockRegistry {
+	return &BlockRegistry{entries: make(map[string]*blockEntry)}
+}
+
+// Register adds a new waiter for etag, creating the entry on first use,
+// and returns the waiter together with a deregister func the caller must
+// run (typically deferred) once it stops waiting.
+func (r *BlockRegistry) Register(etag, backendURL, method string) (*Waiter, func()) {
+	w := &Waiter{Ch: make(chan struct{})}
+
+	r.mu.Lock()
+	entry, ok := r.entries[etag]
+	if !ok {
+		entry = &blockEntry{createdAt: time.Now(), backendURL: backendURL, method: method}
+		r.entries[etag] = entry
+	}
+	entry.waiters = append(entry.waiters, w)
+	r.mu.Unlock()
+
+	deregister := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		entry, ok := r.entries[etag]
+		if !ok {
+			return
+		}
+		for i, ww := range entry.waiters {
+			if ww == w {
+				entry.waiters = append(entry.waiters[:i], entry.waiters[i+1:]...)
+				break
+			}
+		}
+		if len(entry.waiters) == 0 {
+			delete(r.entries, etag)
+		}
+	}
+
+	return w, deregister
+}
+
+// Cancel wakes every waiter registered for etag and drops the entry. It
+// returns how many waiters were woken, so callers can tell a hit from a
+// miss.
+func (r *BlockRegistry) Cancel(etag string) int {
+	r.mu.Lock()
+	entry, ok := r.entries[etag]
+	if ok {
+		delete(r.entries, etag)
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return 0
+	}
+	for _, w := range entry.waiters {
+		w.cancel()
+	}
+	return len(entry.waiters)
+}
+
+// WaiterCounts reports the number of waiters currently blocked per ETag,
+// for /status.
+func (r *BlockRegistry) WaiterCounts() map[string]int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	counts := make(map[string]int, len(r.entries))
+	for etag, entry := range r.entries {
+		counts[etag] = len(entry.waiters)
+	}
+	return counts
+}
+
+// Count returns the number of distinct ETags with at least one waiter.
+func (r *BlockRegistry) Count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.entries)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlockRegistryFanOut(t *testing.T) {
+	r := NewBlockRegistry()
+
+	w1, deregister1 := r.Register("etag-1", "http://backend/a", "GET")
+	defer deregister1()
+	w2, deregister2 := r.Register("etag-1", "http://backend/a", "GET")
+	defer deregister2()
+
+	if got := r.WaiterCounts()["etag-1"]; got != 2 {
+		t.Fatalf("expected 2 waiters for etag-1, got %d", got)
+	}
+
+	if woken := r.Cancel("etag-1"); woken != 2 {
+		t.Fatalf("expected Cancel to wake 2 waiters, got %d", woken)
+	}
+
+	select {
+	case <-w1.Ch:
+	case <-time.After(time.Second):
+		t.Fatal("waiter 1 was not woken")
+	}
+	select {
+	case <-w2.Ch:
+	case <-time.After(time.Second):
+		t.Fatal("waiter 2 was not woken")
+	}
+
+	if got := r.Count(); got != 0 {
+		t.Fatalf("expected entry to be removed after cancel, got count %d", got)
+	}
+}
+
+func TestBlockRegistryCancelUnknownEtag(t *testing.T) {
+	r := NewBlockRegistry()
+	if woken := r.Cancel("missing"); woken != 0 {
+		t.Fatalf("expected 0 waiters woken for unknown etag, got %d", woken)
+	}
+}
+
+func TestBlockRegistryDeregisterRemovesWaiter(t *testing.T) {
+	r := NewBlockRegistry()
+	_, deregister := r.Register("etag-2", "http://backend/b", "GET")
+	deregister()
+
+	if got := r.Count(); got != 0 {
+		t.Fatalf("expected entry to be removed after deregister, got count %d", got)
+	}
+}
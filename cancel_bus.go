@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+)
+
+// instanceID uniquely identifies this proxy process, so a CancelBus can
+// tell its own publishes apart from another instance's.
+var instanceID = uuid.NewString()
+
+// cancelEvent is what gets published on the shared channel for every
+// /cancel call, and what every instance receives back.
+type cancelEvent struct {
+	Etag           string `json:"etag"`
+	SourceInstance string `json:"source_instance"`
+}
+
+// CancelBus fans a /cancel out to every proxy instance sharing the same
+// backing transport, so cancellation works behind a load balancer. The
+// in-memory implementation is the original single-process behavior;
+// Redis and NATS let /cancel reach waiters registered on another
+// instance.
+type CancelBus interface {
+	// Publish announces that etag was cancelled, waking this instance's
+	// own local waiters and notifying every other subscribed instance to
+	// wake theirs. woken is how many local waiters were woken on this
+	// instance. ok reports whether the cancel should be considered to
+	// have succeeded overall: for the in-memory bus that's only true when
+	// a local waiter was actually woken, but for Redis/NATS it's true as
+	// soon as the event is published, since the waiter may be parked on
+	// another instance this one has no way to see.
+	Publish(etag string) (woken int, ok bool, err error)
+	// Connected reports whether the bus is currently able to publish, for
+	// /status.
+	Connected() bool
+	// Kind identifies the transport, for /status (e.g. "memory", "redis").
+	Kind() string
+}
+
+// memoryCancelBus only wakes waiters on the local BlockRegistry, which is
+// the original behavior when no -cancel-bus is configured.
+type memoryCancelBus struct{}
+
+func (memoryCancelBus) Publish(etag string) (int, bool, error) {
+	woken := registry.Cancel(etag)
+	return woken, woken > 0, nil
+}
+func (memoryCancelBus) Connected() bool { return true }
+func (memoryCancelBus) Kind() string    { return "memory" }
+
+// NewCancelBus builds the CancelBus selected by -cancel-bus. An empty
+// addr yields the in-memory bus. Every instance both publishes cancel
+// events (for locally-received /cancel calls) and subscribes to them (to
+// wake local waiters woken by another instance's /cancel).
+func NewCancelBus(addr string) (CancelBus, error) {
+	if addr == "" {
+		return memoryCancelBus{}, nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parse -cancel-bus: %w", err)
+	}
+
+	switch u.Scheme {
+	case "redis", "rediss":
+		return newRedisCancelBus(addr)
+	case "nats":
+		return newNATSCancelBus(addr)
+	default:
+		return nil, fmt.Errorf("unsupported -cancel-bus scheme %q (want redis:// or nats://)", u.Scheme)
+	}
+}
+
+const cancelChannel = "longpull:cancel"
+
+// redisCancelBus publishes/subscribes cancel events over a Redis Pub/Sub
+// channel shared by every proxy instance.
+type redisCancelBus struct {
+	client *redis.Client
+	sub    *redis.PubSub
+}
+
+func newRedisCancelBus(addr string) (*redisCancelBus, error) {
+	opt, err := redis.ParseURL(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis cancel-bus url: %w", err)
+	}
+	client := redis.NewClient(opt)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("connect to redis cancel-bus: %w", err)
+	}
+
+	bus := &redisCancelBus{client: client, sub: client.Subscribe(context.Background(), cancelChannel)}
+	go bus.listen()
+	return bus, nil
+}
+
+func (b *redisCancelBus) listen() {
+	ch := b.sub.Channel()
+	for msg := range ch {
+		deliverRemoteCancel([]byte(msg.Payload))
+	}
+}
+
+func (b *redisCancelBus) Publish(etag string) (int, bool, error) {
+	woken := registry.Cancel(etag) // 本实例立即唤醒
+	payload, err := json.Marshal(cancelEvent{Etag: etag, SourceInstance: instanceID})
+	if err != nil {
+		return woken, woken > 0, err
+	}
+	if err := b.client.Publish(context.Background(), cancelChannel, payload).Err(); err != nil {
+		return woken, woken > 0, err
+	}
+	// 发布成功即视为已送达：等待者可能挂在其他实例上，本实例无法确认
+	return woken, true, nil
+}
+
+// connectedCheckTimeout bounds how long Connected() will block on a
+// round-trip to the bus, so a down Redis/NATS instance can't make
+// /status itself hang for the client's full default timeout.
+const connectedCheckTimeout = 200 * time.Millisecond
+
+func (b *redisCancelBus) Connected() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), connectedCheckTimeout)
+	defer cancel()
+	return b.client.Ping(ctx).Err() == nil
+}
+func (b *redisCancelBus) Kind() string { return "redis" }
+
+// natsCancelBus publishes/subscribes cancel events over a NATS subject
+// shared by every proxy instance.
+type natsCancelBus struct {
+	conn *nats.Conn
+}
+
+func newNATSCancelBus(addr string) (*natsCancelBus, error) {
+	conn, err := nats.Connect(addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect to nats cancel-bus: %w", err)
+	}
+
+	bus := &natsCancelBus{conn: conn}
+	if _, err := conn.Subscribe(cancelChannel, func(msg *nats.Msg) {
+		deliverRemoteCancel(msg.Data)
+	}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("subscribe to nats cancel-bus: %w", err)
+	}
+	return bus, nil
+}
+
+func (b *natsCancelBus) Publish(etag string) (int, bool, error) {
+	woken := registry.Cancel(etag) // 本实例立即唤醒
+	payload, err := json.Marshal(cancelEvent{Etag: etag, SourceInstance: instanceID})
+	if err != nil {
+		return woken, woken > 0, err
+	}
+	if err := b.conn.Publish(cancelChannel, payload); err != nil {
+		return woken, woken > 0, err
+	}
+	// 发布成功即视为已送达：等待者可能挂在其他实例上，本实例无法确认
+	return woken, true, nil
+}
+
+func (b *natsCancelBus) Connected() bool { return b.conn.IsConnected() }
+func (b *natsCancelBus) Kind() string    { return "nats" }
+
+// deliverRemoteCancel handles a cancelEvent received from the bus: skip
+// it if we're the instance that originated it (we already cancelled
+// locally in Publish), otherwise wake our local waiters.
+func deliverRemoteCancel(payload []byte) {
+	var evt cancelEvent
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		logger.Error("failed to decode cancel-bus event", "error", err)
+		return
+	}
+	if evt.SourceInstance == instanceID {
+		return // 防止发布者重复触发
+	}
+	registry.Cancel(evt.Etag)
+}
@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+func TestMemoryCancelBusPublish(t *testing.T) {
+	savedRegistry := registry
+	registry = NewBlockRegistry()
+	defer func() { registry = savedRegistry }()
+
+	waiter, deregister := registry.Register("etag-1", "http://backend/a", "GET")
+	defer deregister()
+
+	bus := memoryCancelBus{}
+	woken, ok, err := bus.Publish("etag-1")
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if woken != 1 {
+		t.Fatalf("expected 1 waiter woken, got %d", woken)
+	}
+	if !ok {
+		t.Fatal("expected Publish to report ok when a waiter was woken")
+	}
+
+	select {
+	case <-waiter.Ch:
+	default:
+		t.Fatal("expected waiter to be woken")
+	}
+
+	if bus.Kind() != "memory" {
+		t.Fatalf("expected kind %q, got %q", "memory", bus.Kind())
+	}
+	if !bus.Connected() {
+		t.Fatal("expected memory bus to always report connected")
+	}
+}
+
+func TestMemoryCancelBusPublishUnknownEtag(t *testing.T) {
+	savedRegistry := registry
+	registry = NewBlockRegistry()
+	defer func() { registry = savedRegistry }()
+
+	bus := memoryCancelBus{}
+	woken, ok, err := bus.Publish("no-such-etag")
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if woken != 0 || ok {
+		t.Fatalf("expected (0, false) for an unknown etag, got (%d, %v)", woken, ok)
+	}
+}
+
+func TestDeliverRemoteCancelIgnoresOwnInstance(t *testing.T) {
+	savedRegistry := registry
+	registry = NewBlockRegistry()
+	defer func() { registry = savedRegistry }()
+
+	waiter, deregister := registry.Register("etag-2", "http://backend/a", "GET")
+	defer deregister()
+
+	payload := []byte(`{"etag":"etag-2","source_instance":"` + instanceID + `"}`)
+	deliverRemoteCancel(payload)
+
+	select {
+	case <-waiter.Ch:
+		t.Fatal("expected own instance's cancel event to be ignored")
+	default:
+	}
+}
+
+func TestDeliverRemoteCancelWakesFromOtherInstance(t *testing.T) {
+	savedRegistry := registry
+	registry = NewBlockRegistry()
+	defer func() { registry = savedRegistry }()
+
+	waiter, deregister := registry.Register("etag-3", "http://backend/a", "GET")
+	defer deregister()
+
+	payload := []byte(`{"etag":"etag-3","source_instance":"some-other-instance"}`)
+	deliverRemoteCancel(payload)
+
+	select {
+	case <-waiter.Ch:
+	default:
+		t.Fatal("expected cancel event from another instance to wake the waiter")
+	}
+}
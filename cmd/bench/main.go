@@ -0,0 +1,253 @@
+// Command bench drives concurrent long-poll traffic against a running
+// longpull-proxy instance and reports latency/block-state statistics,
+// inspired by go-stress-testing's model.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// result is one completed (or failed) long-poll request.
+type result struct {
+	latency time.Duration
+	state   string // pass|cancel|timeout|error, mirrors X-Block-St
+}
+
+// statusResponse mirrors the subset of /status this tool scrapes.
+type statusResponse struct {
+	Goroutines int `json:"goroutines"`
+}
+
+// Report is the aggregated outcome of a bench run, for -json output.
+type Report struct {
+	Total              int            `json:"total"`
+	Errors             int            `json:"errors"`
+	StateCounts        map[string]int `json:"state_counts"`
+	P50Ms              float64        `json:"p50_ms"`
+	P90Ms              float64        `json:"p90_ms"`
+	P99Ms              float64        `json:"p99_ms"`
+	GoroutineHighWater int64          `json:"goroutine_high_water"`
+}
+
+func main() {
+	concurrency := flag.Int("c", 10, "Number of concurrent workers")
+	perWorker := flag.Int("n", 10, "Number of requests per worker")
+	target := flag.String("url", "", "Long-poll URL to hit, e.g. http://localhost:8080/things")
+	wait := flag.Int("wait", 30, "Seconds the backend should hold each long poll open (sent as ?wait=)")
+	cancelRate := flag.Float64("cancel-rate", 0, "Fraction (0-1) of in-flight requests to cancel via /cancel")
+	jsonOutput := flag.Bool("json", false, "Emit a JSON report instead of a human-readable table")
+	flag.Parse()
+
+	if *target == "" {
+		fmt.Println("Please provide -url")
+		os.Exit(1)
+	}
+	base, err := url.Parse(*target)
+	if err != nil {
+		fmt.Println("Invalid -url:", err)
+		os.Exit(1)
+	}
+
+	// 资源的etag相对稳定，足以用来驱动原生长轮询与取消
+	etag := discoverEtag(*target)
+
+	stopWatch := make(chan struct{})
+	var goroutineHighWater int64
+	go watchGoroutines(base, stopWatch, &goroutineHighWater)
+
+	results := make(chan result, *concurrency**perWorker)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < *perWorker; j++ {
+				if etag != "" && *cancelRate > 0 && rand.Float64() < *cancelRate {
+					go scheduleCancel(base, etag, *wait)
+				}
+				results <- doRequest(*target, etag, *wait)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(results)
+	close(stopWatch)
+
+	printReport(results, atomic.LoadInt64(&goroutineHighWater), *jsonOutput)
+}
+
+// discoverEtag makes a plain GET to learn the resource's current ETag,
+// so requests and cancels below can target the same long-poll wait.
+func discoverEtag(target string) string {
+	resp, err := http.Get(target)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return strings.TrimSpace(resp.Header.Get("ETag"))
+}
+
+// doRequest issues one long-poll request and classifies the outcome by
+// its X-Block-St response header.
+func doRequest(target, etag string, wait int) result {
+	start := time.Now()
+	req, err := http.NewRequest(http.MethodGet, withWaitParam(target, wait), nil)
+	if err != nil {
+		return result{state: "error"}
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		return result{latency: latency, state: "error"}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	state := resp.Header.Get("X-Block-St")
+	if state == "" {
+		state = "pass"
+	}
+	return result{latency: latency, state: state}
+}
+
+func withWaitParam(target string, wait int) string {
+	sep := "?"
+	if strings.Contains(target, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%swait=%d", target, sep, wait)
+}
+
+// scheduleCancel waits a random delay within the first half of wait,
+// then hits /cancel?etag=... to exercise the cancel path mid-flight.
+func scheduleCancel(base *url.URL, etag string, wait int) {
+	maxDelayMs := wait * 1000 / 2
+	if maxDelayMs > 0 {
+		time.Sleep(time.Duration(rand.Intn(maxDelayMs)) * time.Millisecond)
+	}
+
+	cancelURL := *base
+	cancelURL.Path = "/cancel"
+	cancelURL.RawQuery = "etag=" + url.QueryEscape(etag)
+
+	resp, err := http.Get(cancelURL.String())
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+}
+
+// watchGoroutines polls /status every 200ms and tracks the highest
+// goroutine count seen, as a proxy for concurrency pressure during the
+// run.
+func watchGoroutines(base *url.URL, stop <-chan struct{}, highWater *int64) {
+	statusURL := *base
+	statusURL.Path = "/status"
+	statusURL.RawQuery = ""
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			resp, err := http.Get(statusURL.String())
+			if err != nil {
+				continue
+			}
+			var sr statusResponse
+			json.NewDecoder(resp.Body).Decode(&sr)
+			resp.Body.Close()
+
+			for {
+				cur := atomic.LoadInt64(highWater)
+				if int64(sr.Goroutines) <= cur {
+					break
+				}
+				if atomic.CompareAndSwapInt64(highWater, cur, int64(sr.Goroutines)) {
+					break
+				}
+			}
+		}
+	}
+}
+
+func printReport(results <-chan result, goroutineHighWater int64, jsonOutput bool) {
+	var latenciesMs []float64
+	counts := map[string]int{}
+	errors := 0
+	for r := range results {
+		counts[r.state]++
+		if r.state == "error" {
+			errors++
+			continue
+		}
+		latenciesMs = append(latenciesMs, float64(r.latency.Milliseconds()))
+	}
+	sort.Float64s(latenciesMs)
+
+	rep := Report{
+		Total:              len(latenciesMs) + errors,
+		Errors:             errors,
+		StateCounts:        counts,
+		P50Ms:              percentile(latenciesMs, 50),
+		P90Ms:              percentile(latenciesMs, 90),
+		P99Ms:              percentile(latenciesMs, 99),
+		GoroutineHighWater: goroutineHighWater,
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+		return
+	}
+
+	fmt.Printf("Total requests       : %d\n", rep.Total)
+	fmt.Printf("Errors               : %d\n", rep.Errors)
+	fmt.Printf("Goroutine high-water : %d\n", rep.GoroutineHighWater)
+	fmt.Println("Block state counts:")
+	for _, state := range []string{"pass", "cancel", "timeout", "error"} {
+		if c, ok := counts[state]; ok {
+			fmt.Printf("  %-8s: %d\n", state, c)
+		}
+	}
+	fmt.Printf("Latency p50/p90/p99  : %.1fms / %.1fms / %.1fms\n", rep.P50Ms, rep.P90Ms, rep.P99Ms)
+}
+
+// percentile returns the p-th percentile (0-100) of sorted, a
+// nearest-rank estimate.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+
+	if got := percentile(sorted, 50); got != 50 {
+		t.Fatalf("p50: expected 50, got %v", got)
+	}
+	if got := percentile(sorted, 90); got != 90 {
+		t.Fatalf("p90: expected 90, got %v", got)
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Fatalf("empty input: expected 0, got %v", got)
+	}
+}
+
+func TestWithWaitParam(t *testing.T) {
+	if got := withWaitParam("http://host/path", 30); got != "http://host/path?wait=30" {
+		t.Fatalf("unexpected url: %s", got)
+	}
+	if got := withWaitParam("http://host/path?x=1", 30); got != "http://host/path?x=1&wait=30" {
+		t.Fatalf("unexpected url: %s", got)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// logger is the structured, JSON-emitting logger used throughout the
+// proxy in place of the old ad-hoc log.Printf calls. newLogger in main
+// sets its level from -debug.
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// newLogger builds the request logger: JSON to stdout, at debug level
+// when -debug is set and info level otherwise.
+func newLogger(debug bool) *slog.Logger {
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
+const requestIDHeader = "X-Request-Id"
+
+// requestID returns r's X-Request-Id if it set one, generating a fresh
+// one otherwise so every request can be traced end to end.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+	return uuid.NewString()
+}
+
+// logRequest emits the one-JSON-record-per-request summary: method,
+// path, etag, block state, how long (if at all) the request was blocked,
+// backend latency, and the status code returned to the client.
+func logRequest(r *http.Request, requestID, etag, blockState string, blockWait, backendLatency time.Duration, status int) {
+	logger.Info("request",
+		"request_id", requestID,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"etag", etag,
+		"block_state", blockState,
+		"block_wait_ms", blockWait.Milliseconds(),
+		"backend_latency_ms", backendLatency.Milliseconds(),
+		"status", status,
+	)
+}
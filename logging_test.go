@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRequestIDUsesExistingHeader(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(requestIDHeader, "abc-123")
+
+	if got := requestID(r); got != "abc-123" {
+		t.Fatalf("requestID() = %q, want %q", got, "abc-123")
+	}
+}
+
+func TestRequestIDGeneratesWhenMissing(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+
+	first := requestID(r)
+	if first == "" {
+		t.Fatal("requestID() returned empty string")
+	}
+	if second := requestID(r); second == first {
+		t.Fatalf("requestID() returned the same id twice: %q", first)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// revalidateInterval is how often handleNativeLongPoll re-polls the
+// backend while waiting for a change, in case the backend has no push
+// path of its own. Configurable via -revalidate-interval.
+var revalidateInterval = 2 * time.Second
+
+// parseNativeLongPoll reports whether r opted into client-driven long-poll
+// semantics: an If-None-Match etag plus a wait time from either
+// `Prefer: wait=<seconds>` (RFC 7240) or a `?wait=<seconds>` query param.
+func parseNativeLongPoll(r *http.Request) (wait time.Duration, etag string, ok bool) {
+	etag = strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if etag == "" {
+		return 0, "", false
+	}
+	wait, ok = parseWaitDuration(r)
+	if !ok {
+		return 0, "", false
+	}
+	return wait, etag, true
+}
+
+// parseWaitDuration extracts the requested wait time from ?wait=Ns or
+// Prefer: wait=N.
+func parseWaitDuration(r *http.Request) (time.Duration, bool) {
+	if v := r.URL.Query().Get("wait"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return time.Duration(n) * time.Second, true
+		}
+	}
+	for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+		pref = strings.TrimSpace(pref)
+		if n, found := strings.CutPrefix(pref, "wait="); found {
+			if secs, err := strconv.Atoi(n); err == nil && secs > 0 {
+				return time.Duration(secs) * time.Second, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// handleNativeLongPoll serves a request that opted into client-driven
+// long-poll via If-None-Match + Prefer:wait (see parseNativeLongPoll). It
+// forwards to the backend itself, and if the backend's current
+// representation still matches the client's etag, holds the connection
+// open until a /cancel push, a revalidation poll notices a change, or
+// wait elapses (replying 304 Not Modified on timeout).
+func handleNativeLongPoll(w http.ResponseWriter, r *http.Request, body []byte, backendURL string, backend *Backend, timeout time.Duration, clientEtag string, wait time.Duration, reqID string) {
+	start := time.Now()
+	deadline := start.Add(wait)
+
+	poll := func() (*http.Response, time.Duration, error) {
+		req, err := http.NewRequest(r.Method, backendURL, strings.NewReader(string(body)))
+		if err != nil {
+			return nil, 0, err
+		}
+		copyHeaders(req.Header, r.Header)
+		setForwardingHeaders(req, r)
+		req.Header.Set(requestIDHeader, reqID)
+		// 条件判断由代理自己做，后端始终返回当前表示
+		req.Header.Del("If-None-Match")
+		req.Header.Del("Prefer")
+
+		backendStart := time.Now()
+		resp, err := clientForTimeout(timeout).Do(req)
+		latency := time.Since(backendStart)
+		if backend != nil {
+			backend.markHealthy(err == nil)
+		}
+		return resp, latency, err
+	}
+
+	resp, latency, err := poll()
+	if err != nil {
+		backendErrorsTotal.WithLabelValues(r.Method, metricsPath(r)).Inc()
+		logger.Error("failed to send proxy request", "request_id", reqID, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if changed, _ := etagChanged(resp, clientEtag); changed {
+		writeLongPollResponse(w, r, reqID, resp, "pass", start, 0, latency)
+		return
+	}
+	resp.Body.Close()
+
+	//未变化，注册等待者，等待 /cancel 推送、轮询发现变化或超时
+	waiter, deregister := registry.Register(clientEtag, backendURL, r.Method)
+	defer deregister()
+
+	activeBlocks.Inc()
+	blockStart := time.Now()
+	defer activeBlocks.Dec()
+
+	ticker := time.NewTicker(revalidateInterval)
+	defer ticker.Stop()
+
+	// One timer for the whole wait, reused across iterations, instead of
+	// a fresh time.After per loop — each ticker tick would otherwise
+	// abandon that iteration's timer to fire (and get GC'd) on its own
+	// schedule, leaking a live runtime timer for up to a full wait per
+	// revalidation.
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-waiter.Ch:
+			resp, latency, err := poll()
+			blockWait := time.Since(blockStart)
+			blockDuration.Observe(blockWait.Seconds())
+			if err != nil {
+				backendErrorsTotal.WithLabelValues(r.Method, metricsPath(r)).Inc()
+				logger.Error("failed to fetch updated result", "request_id", reqID, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			writeLongPollResponse(w, r, reqID, resp, "cancel", start, blockWait, latency)
+			return
+		case <-ticker.C:
+			resp, latency, err := poll()
+			if err != nil {
+				backendErrorsTotal.WithLabelValues(r.Method, metricsPath(r)).Inc()
+				logger.Error("failed to revalidate", "request_id", reqID, "error", err)
+				continue
+			}
+			if changed, _ := etagChanged(resp, clientEtag); changed {
+				blockWait := time.Since(blockStart)
+				blockDuration.Observe(blockWait.Seconds())
+				writeLongPollResponse(w, r, reqID, resp, "pass", start, blockWait, latency)
+				return
+			}
+			resp.Body.Close()
+		case <-timer.C:
+			blockWait := time.Since(blockStart)
+			blockDuration.Observe(blockWait.Seconds())
+			writeNotModified(w, r, reqID, start, blockWait)
+			return
+		}
+	}
+}
+
+// etagChanged reports whether resp no longer represents clientEtag,
+// i.e. it isn't a 200 with the same ETag the client already has.
+func etagChanged(resp *http.Response, clientEtag string) (bool, string) {
+	etag := strings.TrimSpace(resp.Header.Get("ETag"))
+	return resp.StatusCode != http.StatusOK || etag != clientEtag, etag
+}
+
+func writeLongPollResponse(w http.ResponseWriter, r *http.Request, reqID string, resp *http.Response, state string, start time.Time, blockWait, backendLatency time.Duration) {
+	defer resp.Body.Close()
+	copyHeaders(w.Header(), resp.Header)
+	w.Header().Set("X-Block-St", state)
+	w.Header().Set("X-Block-St-Time", time.Since(start).String())
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		logger.Error("failed to write response", "request_id", reqID, "error", err)
+	}
+	requestsTotal.WithLabelValues(r.Method, metricsPath(r), state).Inc()
+	logRequest(r, reqID, strings.TrimSpace(resp.Header.Get("ETag")), state, blockWait, backendLatency, resp.StatusCode)
+}
+
+func writeNotModified(w http.ResponseWriter, r *http.Request, reqID string, start time.Time, blockWait time.Duration) {
+	w.Header().Set("X-Block-St", "timeout")
+	w.Header().Set("X-Block-St-Time", time.Since(start).String())
+	w.WriteHeader(http.StatusNotModified)
+	requestsTotal.WithLabelValues(r.Method, metricsPath(r), "timeout").Inc()
+	logRequest(r, reqID, "", "timeout", blockWait, 0, http.StatusNotModified)
+}
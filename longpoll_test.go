@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseNativeLongPollQueryWait(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://proxy/things?wait=5", nil)
+	req.Header.Set("If-None-Match", `"abc"`)
+
+	wait, etag, ok := parseNativeLongPoll(req)
+	if !ok {
+		t.Fatal("expected native long poll to be detected")
+	}
+	if wait != 5*time.Second {
+		t.Fatalf("expected 5s wait, got %v", wait)
+	}
+	if etag != `"abc"` {
+		t.Fatalf("expected etag to be passed through, got %q", etag)
+	}
+}
+
+func TestParseNativeLongPollPreferHeader(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://proxy/things", nil)
+	req.Header.Set("If-None-Match", `"abc"`)
+	req.Header.Set("Prefer", "wait=30")
+
+	wait, _, ok := parseNativeLongPoll(req)
+	if !ok {
+		t.Fatal("expected native long poll to be detected")
+	}
+	if wait != 30*time.Second {
+		t.Fatalf("expected 30s wait, got %v", wait)
+	}
+}
+
+func TestParseNativeLongPollRequiresEtagAndWait(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://proxy/things", nil)
+	if _, _, ok := parseNativeLongPoll(req); ok {
+		t.Fatal("expected no match without If-None-Match or wait")
+	}
+
+	req.Header.Set("If-None-Match", `"abc"`)
+	if _, _, ok := parseNativeLongPoll(req); ok {
+		t.Fatal("expected no match without a wait value")
+	}
+}
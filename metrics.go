@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "longpull_requests_total",
+		Help: "Total number of proxied requests, by method, path and block state.",
+	}, []string{"method", "path", "block_state"})
+
+	blockDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "longpull_block_duration_seconds",
+		Help:    "How long requests spent blocked waiting for a long-poll to resolve.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	activeBlocks = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "longpull_active_blocks",
+		Help: "Number of requests currently blocked waiting for a long-poll to resolve.",
+	})
+
+	cancelTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "longpull_cancel_total",
+		Help: "Total number of /cancel calls, by result.",
+	}, []string{"result"})
+
+	backendErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "longpull_backend_errors_total",
+		Help: "Total number of failed backend requests, by method and path.",
+	}, []string{"method", "path"})
+)
+
+// metricsHandler exposes the counters/histograms above plus the standard
+// process/go collectors, for /metrics.
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+var idSegment = regexp.MustCompile(`^[0-9]+$|^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$|^[0-9a-fA-F]{16,}$`)
+
+// metricsPath returns a bounded-cardinality label for r's path: the
+// matched route's path_prefix when routing via -config (one label value
+// per configured route), or r.URL.Path with numeric/hex/UUID-looking
+// segments collapsed to ":id" otherwise. r.URL.Path itself forwards
+// arbitrary backend paths and would otherwise blow up requestsTotal's
+// and backendErrorsTotal's series count under real traffic.
+func metricsPath(r *http.Request) string {
+	if router != nil {
+		if rt := router.match(r); rt != nil {
+			return rt.pathPrefix
+		}
+		return "unmatched"
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+	for i, seg := range segments {
+		if idSegment.MatchString(seg) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
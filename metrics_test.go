@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsPathNormalizesIDSegments(t *testing.T) {
+	oldRouter := router
+	router = nil
+	defer func() { router = oldRouter }()
+
+	r := httptest.NewRequest(http.MethodGet, "/users/12345/orders/a1b2c3d4-e5f6-7890-abcd-ef1234567890", nil)
+	if got, want := metricsPath(r), "/users/:id/orders/:id"; got != want {
+		t.Fatalf("metricsPath() = %q, want %q", got, want)
+	}
+}
+
+func TestMetricsPathUsesRoutePrefixWhenConfigured(t *testing.T) {
+	oldRouter := router
+	router = &Router{routes: []*route{{pathPrefix: "/api/widgets"}}}
+	defer func() { router = oldRouter }()
+
+	r := httptest.NewRequest(http.MethodGet, "/api/widgets/999", nil)
+	if got, want := metricsPath(r), "/api/widgets"; got != want {
+		t.Fatalf("metricsPath() = %q, want %q", got, want)
+	}
+}
+
+func TestMetricsPathUnmatchedWhenRoutedButNoRouteMatches(t *testing.T) {
+	oldRouter := router
+	router = &Router{routes: []*route{{pathPrefix: "/api/widgets"}}}
+	defer func() { router = oldRouter }()
+
+	r := httptest.NewRequest(http.MethodGet, "/other", nil)
+	if got, want := metricsPath(r), "unmatched"; got != want {
+		t.Fatalf("metricsPath() = %q, want %q", got, want)
+	}
+}
@@ -5,12 +5,14 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
 	"os"
-	"reflect"
+	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -20,86 +22,168 @@ var transport = &http.Transport{
 	IdleConnTimeout:     30 * time.Second, // 空闲连接的超时时间
 }
 var (
-	//blockMap    sync.Map //不用使用线程安全
-	blockMap = make(map[string]chan<- struct{})
+	registry = NewBlockRegistry() //按etag分组的阻塞等待者注册表，并发安全
 	client   = &http.Client{
 		Transport: transport,
 	}
 	backendBase string
+	router      *Router                       // 非nil时按-config的路由表转发，否则回退到单一backendBase
+	cancelBus   CancelBus = memoryCancelBus{} // /cancel 的传播方式，默认仅本进程内存
 )
 
 type StatusResponse struct {
-	Goroutines int `json:"goroutines"`
-	BlockCount int `json:"block_count"`
+	Goroutines   int             `json:"goroutines"`
+	BlockCount   int             `json:"block_count"`
+	BlockWaiters map[string]int  `json:"block_waiters"`
+	Routes       []RouteStatus   `json:"routes,omitempty"`
+	CancelBus    CancelBusStatus `json:"cancel_bus"`
+}
+
+// CancelBusStatus is the /status view of the configured CancelBus.
+type CancelBusStatus struct {
+	Kind      string `json:"kind"`
+	Connected bool   `json:"connected"`
 }
 
 func main() {
 	// 通过命令行参数指定运行端口和后端地址
 	port := flag.Int("port", 8080, "The port number to run the proxy server")
 	backend := flag.String("backend", "", "The backend server URL")
+	configPath := flag.String("config", "", "Path to a YAML/JSON routing config (multiple upstreams, load balancing)")
+	revalidateSeconds := flag.Int("revalidate-interval", 2, "Seconds between backend revalidation polls during a native long-poll wait")
+	cancelBusAddr := flag.String("cancel-bus", "", "Cancellation transport for horizontal scaling: redis://... or nats://... (default: in-memory, single instance only)")
 	debug := flag.Int("debug", 0, "show debug log")
 	flag.Parse()
 
-	if *debug > 0 {
-		log.SetOutput(os.Stdout)
-	} else {
-		log.SetOutput(io.Discard)
-		log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	revalidateInterval = time.Duration(*revalidateSeconds) * time.Second
+	logger = newLogger(*debug > 0)
+
+	bus, err := NewCancelBus(*cancelBusAddr)
+	if err != nil {
+		fmt.Println("Failed to set up cancel bus:", err)
+		os.Exit(1)
 	}
+	cancelBus = bus
 
-	// 验证命令行参数
-	if *backend == "" {
-		fmt.Println("Please provide the backend server URL")
+	// 验证命令行参数：-config 和 -backend 至少提供一个
+	if *configPath == "" && *backend == "" {
+		fmt.Println("Please provide either -backend or -config")
 		os.Exit(1)
 	}
-	backendBase = strings.TrimRight(*backend, "/") //去除末尾反斜杠
+	if *backend != "" {
+		backendBase = strings.TrimRight(*backend, "/") //去除末尾反斜杠
+	}
+
+	if *configPath != "" {
+		r, err := NewRouter(*configPath)
+		if err != nil {
+			fmt.Println("Failed to load routing config:", err)
+			os.Exit(1)
+		}
+		router = r
+		watchConfigReload(*configPath)
+	}
 
 	//http.HandleFunc("/", helloHandler)
 	http.HandleFunc("/cancel", cancelBlockHandler) //取消阻塞
 	http.HandleFunc("/status", statusHandler)      //状态
+	http.Handle("/metrics", metricsHandler())      //Prometheus指标
 	http.HandleFunc("/", proxyHandler)             //请求路径
 
 	addr := fmt.Sprintf(":%d", *port)
-	fmt.Printf("Proxy server is running on port %s...\nbackend %s\ndebug %d\n", addr, backendBase, *debug)
+	fmt.Printf("Proxy server is running on port %s...\nbackend %s\nconfig %s\ndebug %d\n", addr, backendBase, *configPath, *debug)
 	fmt.Println(http.ListenAndServe(addr, nil))
 }
 
+// watchConfigReload installs a SIGHUP handler that reloads the routing
+// table from configPath, swapping it in atomically via Router.Reload.
+func watchConfigReload(configPath string) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			if err := router.Reload(); err != nil {
+				logger.Error("config reload failed", "error", err)
+				continue
+			}
+			logger.Info("config reloaded", "path", configPath)
+		}
+	}()
+}
+
 func proxyHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
+	reqID := requestID(r)
+	w.Header().Set(requestIDHeader, reqID)
+
 	// 读取请求体
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Println("Failed to read request body:", err)
+		logger.Error("failed to read request body", "request_id", reqID, "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// 配置后端服务器地址
-	backendURL := backendBase + r.URL.Path
-	log.Printf("%s %s", r.Method, backendURL)
+	// 配置后端服务器地址（单后端或按路由表选择）
+	backendURL, backend, timeout, release, err := selectBackend(r)
+	if err != nil {
+		logger.Error("failed to select backend", "request_id", reqID, "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer release()
+	logger.Debug("routed request", "request_id", reqID, "method", r.Method, "backend_url", backendURL)
+
+	// WebSocket 升级：hijack 原始连接并双向转发字节，block 逻辑不适用
+	if isWebSocketUpgrade(r) {
+		r.Header.Set(requestIDHeader, reqID)
+		handleWebSocketUpgrade(w, r, backendURL, backend, reqID)
+		return
+	}
+
+	// 客户端驱动的原生长轮询：If-None-Match + Prefer:wait / ?wait=
+	if wait, clientEtag, ok := parseNativeLongPoll(r); ok {
+		handleNativeLongPoll(w, r, body, backendURL, backend, timeout, clientEtag, wait, reqID)
+		return
+	}
 
 	// 创建代理请求
 	proxyReq, err := http.NewRequest(r.Method, backendURL, strings.NewReader(string(body)))
 	if err != nil {
-		log.Println("Failed to create proxy request:", err)
+		logger.Error("failed to create proxy request", "request_id", reqID, "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	// 复制请求头
+	// 复制请求头，并附加转发相关的头部
 	copyHeaders(proxyReq.Header, r.Header)
-
-	// 发送代理请求
-	resp, err := client.Do(proxyReq)
+	setForwardingHeaders(proxyReq, r)
+	proxyReq.Header.Set(requestIDHeader, reqID)
+
+	// 发送代理请求，按路由配置的 timeout_ms 限定等待响应头的时间
+	backendStart := time.Now()
+	resp, err := clientForTimeout(timeout).Do(proxyReq)
+	backendLatency := time.Since(backendStart)
+	if backend != nil {
+		backend.markHealthy(err == nil)
+	}
 	if err != nil {
-		log.Println("Failed to send proxy request:", err)
+		backendErrorsTotal.WithLabelValues(r.Method, metricsPath(r)).Inc()
+		logger.Error("failed to send proxy request", "request_id", reqID, "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
 	defer resp.Body.Close()
 
+	// SSE / chunked 流式响应：跳过 block 逻辑，边读边写并及时 flush
+	if isStreamingResponse(resp) {
+		handleStreamingResponse(w, r, resp, reqID, backendLatency)
+		return
+	}
+
 	//检查是否需要block
 	blockHeader := strings.TrimSpace(resp.Header.Get("x-block"))
 	if blockHeader == "" {
@@ -111,8 +195,10 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 		// 将响应返回给客户端
 		w.WriteHeader(resp.StatusCode)
 		if _, err := io.Copy(w, resp.Body); err != nil {
-			log.Println("Failed to write response:", err)
+			logger.Error("failed to write response", "request_id", reqID, "error", err)
 		}
+		requestsTotal.WithLabelValues(r.Method, metricsPath(r), "pass").Inc()
+		logRequest(r, reqID, "", "pass", 0, backendLatency, resp.StatusCode)
 		return
 	}
 
@@ -120,49 +206,44 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 	var blockTime int
 	_, err = fmt.Sscanf(blockHeader, "%ds", &blockTime)
 	if err != nil {
-		log.Println("Failed to parse block time:", err)
+		logger.Error("failed to parse block time", "request_id", reqID, "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	cancelCh := make(chan struct{})
-
 	//get header etag
 	etagHeader := strings.TrimSpace(resp.Header.Get("etag"))
+
+	var waiterCh <-chan struct{}
 	if etagHeader != "" {
-		//通过etag可以取消阻塞
-		blockMap[etagHeader] = cancelCh //存储阻塞chanel
-
-		//同一个chanel再删除
-		defer func() {
-			if cancelCh2, ok := blockMap[etagHeader]; ok {
-				if isSameChannel(cancelCh, cancelCh2) {
-					delete(blockMap, etagHeader)
-				} else {
-					log.Printf("%s is dup", etagHeader)
-				}
-			}
-		}()
+		//通过etag注册等待者，支持同一个etag上的多个并发长轮询
+		waiter, deregister := registry.Register(etagHeader, backendURL, r.Method)
+		waiterCh = waiter.Ch
+		defer deregister()
 	}
 
 	var isCancel bool //确认是否被取消
 
 	//阻塞
-	log.Printf("%s %s %ds %s", r.Method, r.URL.Path, blockTime, etagHeader)
+	activeBlocks.Inc()
+	blockStart := time.Now()
+	logger.Debug("blocking", "request_id", reqID, "method", r.Method, "path", r.URL.Path, "block_time_s", blockTime, "etag", etagHeader)
 	select {
 	case <-time.After(time.Duration(blockTime) * time.Second):
 		isCancel = false
-	case <-cancelCh:
-		log.Printf("%s %s is cancel", r.Method, r.URL.Path)
+	case <-waiterCh:
 		isCancel = true
 	}
+	activeBlocks.Dec()
+	blockWait := time.Since(blockStart)
+	blockDuration.Observe(blockWait.Seconds())
 
 	if isCancel {
 		// 收到取消阻塞的信号，从新到后端获取最新结果
-		newResp, err := client.Do(proxyReq)
+		newResp, err := clientForTimeout(timeout).Do(proxyReq)
 		if err != nil {
-			log.Println("Failed to fetch updated result:", err)
+			logger.Error("failed to fetch updated result", "request_id", reqID, "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -173,8 +254,10 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Block-St-Time", time.Since(start).String())
 		w.WriteHeader(newResp.StatusCode)
 		if _, err := io.Copy(w, newResp.Body); err != nil {
-			log.Println("Failed to write response:", err)
+			logger.Error("failed to write response", "request_id", reqID, "error", err)
 		}
+		requestsTotal.WithLabelValues(r.Method, metricsPath(r), "cancel").Inc()
+		logRequest(r, reqID, etagHeader, "cancel", blockWait, backendLatency, newResp.StatusCode)
 	} else {
 		// 阻塞时间到达后继续执行
 		copyHeaders(w.Header(), resp.Header)
@@ -182,8 +265,10 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Block-St-Time", time.Since(start).String())
 		w.WriteHeader(resp.StatusCode)
 		if _, err := io.Copy(w, resp.Body); err != nil {
-			log.Println("Failed to write response:", err)
+			logger.Error("failed to write response", "request_id", reqID, "error", err)
 		}
+		requestsTotal.WithLabelValues(r.Method, metricsPath(r), "timeout").Inc()
+		logRequest(r, reqID, etagHeader, "timeout", blockWait, backendLatency, resp.StatusCode)
 	}
 }
 
@@ -206,23 +291,19 @@ func cancelBlockHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cancelCh, ok := blockMap[etag]
+	woken, ok, err := cancelBus.Publish(etag)
+	if err != nil {
+		logger.Error("cancel-bus publish failed", "etag", etag, "error", err)
+	}
 	if !ok {
+		cancelTotal.WithLabelValues("not_found").Inc()
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte(`{"code":2, "msg":"etag is not found"}`))
 		return
 	}
-
-	delete(blockMap, etag) //主动删除map
-
-	//线程不安全，小心重复关闭
-	select {
-	case cancelCh <- struct{}{}:
-		log.Printf("tag:%s cancelCh is send", etag)
-	default:
-		log.Printf("cancelCh is closed")
-	}
+	cancelTotal.WithLabelValues("ok").Inc()
+	logger.Info("cancelled waiters", "etag", etag, "woken", woken)
 
 	//返回json，json中提示 cancelok
 	w.Header().Set("Content-Type", "application/json")
@@ -233,8 +314,13 @@ func cancelBlockHandler(w http.ResponseWriter, r *http.Request) {
 func statusHandler(w http.ResponseWriter, r *http.Request) {
 	goroutines := runtime.NumGoroutine()
 	status := StatusResponse{
-		Goroutines: goroutines,
-		BlockCount: len(blockMap),
+		Goroutines:   goroutines,
+		BlockCount:   registry.Count(),
+		BlockWaiters: registry.WaiterCounts(),
+		CancelBus:    CancelBusStatus{Kind: cancelBus.Kind(), Connected: cancelBus.Connected()},
+	}
+	if router != nil {
+		status.Routes = router.Status()
 	}
 	responseJSON, err := json.Marshal(status)
 	if err != nil {
@@ -246,6 +332,77 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(responseJSON)
 }
 
+// selectBackend picks the backend URL for r: via the Router's routing
+// table when -config is in use, otherwise the single -backend. It
+// returns a release func (decrements the chosen backend's connection
+// count for least_conn) that callers must defer, the Backend itself so
+// the caller can report health back (nil in single-backend mode), and
+// the route's configured timeout_ms (0 when unset, or in single-backend
+// mode) for use with clientForTimeout.
+func selectBackend(r *http.Request) (backendURL string, backend *Backend, timeout time.Duration, release func(), err error) {
+	if router == nil {
+		return backendBase + r.URL.Path, nil, 0, func() {}, nil
+	}
+
+	rt := router.match(r)
+	if rt == nil {
+		return "", nil, 0, nil, fmt.Errorf("no route matches %s %s", r.Host, r.URL.Path)
+	}
+	b := rt.pick()
+	if b == nil {
+		return "", nil, 0, nil, fmt.Errorf("no healthy backend for route %s", rt.pathPrefix)
+	}
+	b.acquire()
+	return b.Addr + r.URL.Path, b, rt.timeout, func() { b.release() }, nil
+}
+
+// timeoutClients caches one *http.Client per distinct route timeout_ms,
+// each sharing transport's connection pool but with its own
+// ResponseHeaderTimeout. That timeout only bounds the wait for the
+// backend's response headers, not the time spent reading its body
+// afterward, so it can't cut off a long-lived SSE/chunked stream or
+// WebSocket passthrough.
+var (
+	timeoutClientsMu sync.Mutex
+	timeoutClients   = map[time.Duration]*http.Client{}
+)
+
+// clientForTimeout returns the shared client when timeout is unset, or a
+// cached client whose backend requests give up waiting for response
+// headers after timeout.
+func clientForTimeout(timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		return client
+	}
+
+	timeoutClientsMu.Lock()
+	defer timeoutClientsMu.Unlock()
+	if c, ok := timeoutClients[timeout]; ok {
+		return c
+	}
+	t := transport.Clone()
+	t.ResponseHeaderTimeout = timeout
+	c := &http.Client{Transport: t}
+	timeoutClients[timeout] = c
+	return c
+}
+
+// setForwardingHeaders annotates proxyReq with the standard reverse-proxy
+// headers so the backend can see the original client and hop chain.
+func setForwardingHeaders(proxyReq *http.Request, r *http.Request) {
+	clientIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	proxyReq.Header.Set("X-Real-IP", clientIP)
+	if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+		proxyReq.Header.Set("X-Forwarded-For", prior+", "+clientIP)
+	} else {
+		proxyReq.Header.Set("X-Forwarded-For", clientIP)
+	}
+	proxyReq.Header.Add("Via", "1.1 longpull-proxy")
+}
+
 func copyHeaders(dest, src http.Header) {
 	for key, values := range src {
 		for _, value := range values {
@@ -253,17 +410,3 @@ func copyHeaders(dest, src http.Header) {
 		}
 	}
 }
-
-// 判断两个 chan 是否是同一个
-func isSameChannel(ch1, ch2 interface{}) bool {
-	// 将 chan 的指针转换为 reflect.Value
-	v1 := reflect.ValueOf(ch1)
-	v2 := reflect.ValueOf(ch2)
-
-	// 获取指针的地址
-	p1 := v1.Pointer()
-	p2 := v2.Pointer()
-
-	// 比较两个指针的地址是否相同
-	return p1 == p2
-}
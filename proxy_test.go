@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientForTimeoutZeroReturnsSharedClient(t *testing.T) {
+	if got := clientForTimeout(0); got != client {
+		t.Fatalf("expected clientForTimeout(0) to return the shared client, got %v", got)
+	}
+}
+
+func TestClientForTimeoutSetsResponseHeaderTimeout(t *testing.T) {
+	got := clientForTimeout(5 * time.Second)
+	if got == client {
+		t.Fatal("expected a distinct client for a non-zero timeout")
+	}
+	tr, ok := got.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected *http.Transport, got %T", got.Transport)
+	}
+	if tr.ResponseHeaderTimeout != 5*time.Second {
+		t.Fatalf("expected ResponseHeaderTimeout 5s, got %v", tr.ResponseHeaderTimeout)
+	}
+
+	// Same timeout should reuse the cached client.
+	if again := clientForTimeout(5 * time.Second); again != got {
+		t.Fatal("expected clientForTimeout to cache clients per timeout")
+	}
+}
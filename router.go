@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load-balancing strategies a route can pick its backend pool with.
+const (
+	BalanceRoundRobin       = "round_robin"
+	BalanceLeastConnections = "least_conn"
+)
+
+// Backend is a single upstream address within a route's pool.
+type Backend struct {
+	Addr string `json:"addr" yaml:"addr"`
+
+	conns   int64 // 当前使用中的连接数，用于 least_conn 选择
+	healthy int32 // 1=健康 0=不健康，原子访问
+}
+
+func newBackend(addr string) *Backend {
+	return &Backend{Addr: strings.TrimRight(addr, "/"), healthy: 1}
+}
+
+func (b *Backend) acquire() { atomic.AddInt64(&b.conns, 1) }
+func (b *Backend) release() { atomic.AddInt64(&b.conns, -1) }
+
+func (b *Backend) markHealthy(ok bool) {
+	if ok {
+		atomic.StoreInt32(&b.healthy, 1)
+	} else {
+		atomic.StoreInt32(&b.healthy, 0)
+	}
+}
+
+func (b *Backend) isHealthy() bool { return atomic.LoadInt32(&b.healthy) != 0 }
+
+// RouteConfig describes one routing rule as loaded from the -config file.
+type RouteConfig struct {
+	PathPrefix string   `json:"path_prefix" yaml:"path_prefix"`
+	Host       string   `json:"host" yaml:"host"`
+	Backends   []string `json:"backends" yaml:"backends"`
+	Balance    string   `json:"balance" yaml:"balance"`
+	TimeoutMs  int      `json:"timeout_ms" yaml:"timeout_ms"`
+}
+
+// RoutingConfig is the top-level shape of the -config file.
+type RoutingConfig struct {
+	Routes []RouteConfig `json:"routes" yaml:"routes"`
+}
+
+// route is a RouteConfig resolved into a runtime-ready backend pool.
+type route struct {
+	pathPrefix string
+	host       string
+	balance    string
+	timeout    time.Duration
+	backends   []*Backend
+	rrCursor   uint64 // round-robin 游标
+}
+
+// pick selects the next backend for this route according to its
+// configured balancing strategy, skipping unhealthy backends.
+func (rt *route) pick() *Backend {
+	healthy := make([]*Backend, 0, len(rt.backends))
+	for _, b := range rt.backends {
+		if b.isHealthy() {
+			healthy = append(healthy, b)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	if rt.balance == BalanceLeastConnections {
+		best := healthy[0]
+		for _, b := range healthy[1:] {
+			if atomic.LoadInt64(&b.conns) < atomic.LoadInt64(&best.conns) {
+				best = b
+			}
+		}
+		return best
+	}
+
+	idx := atomic.AddUint64(&rt.rrCursor, 1)
+	return healthy[idx%uint64(len(healthy))]
+}
+
+// matches reports whether this route handles the given request.
+func (rt *route) matches(r *http.Request) bool {
+	if rt.host != "" && rt.host != r.Host {
+		return false
+	}
+	if rt.pathPrefix != "" && !strings.HasPrefix(r.URL.Path, rt.pathPrefix) {
+		return false
+	}
+	return true
+}
+
+// Router holds the routing table built from the -config file and
+// dispatches requests to the right route's backend pool. It's safe for
+// concurrent use; Reload atomically swaps the whole table.
+type Router struct {
+	mu     sync.RWMutex
+	routes []*route
+	path   string
+}
+
+// NewRouter loads the routing table from path. An empty path yields a
+// Router with no routes, so callers can fall back to single-backend mode.
+func NewRouter(path string) (*Router, error) {
+	r := &Router{path: path}
+	if path == "" {
+		return r, nil
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the config file and atomically swaps in the new
+// routing table. Existing in-flight requests keep using the table they
+// started with.
+func (r *Router) Reload() error {
+	cfg, err := loadRoutingConfig(r.path)
+	if err != nil {
+		return err
+	}
+
+	routes := make([]*route, 0, len(cfg.Routes))
+	for _, rc := range cfg.Routes {
+		if len(rc.Backends) == 0 {
+			return fmt.Errorf("route %q has no backends", rc.PathPrefix)
+		}
+		balance := rc.Balance
+		if balance == "" {
+			balance = BalanceRoundRobin
+		}
+		timeout := 30 * time.Second
+		if rc.TimeoutMs > 0 {
+			timeout = time.Duration(rc.TimeoutMs) * time.Millisecond
+		}
+		backends := make([]*Backend, 0, len(rc.Backends))
+		for _, addr := range rc.Backends {
+			backends = append(backends, newBackend(addr))
+		}
+		routes = append(routes, &route{
+			pathPrefix: rc.PathPrefix,
+			host:       rc.Host,
+			balance:    balance,
+			timeout:    timeout,
+			backends:   backends,
+		})
+	}
+
+	r.mu.Lock()
+	r.routes = routes
+	r.mu.Unlock()
+	return nil
+}
+
+// match returns the first route whose host/path-prefix rule matches req.
+func (r *Router) match(req *http.Request) *route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rt := range r.routes {
+		if rt.matches(req) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// RouteStatus is the /status view of one route's backend pool.
+type RouteStatus struct {
+	PathPrefix string          `json:"path_prefix,omitempty"`
+	Host       string          `json:"host,omitempty"`
+	Balance    string          `json:"balance"`
+	Backends   []BackendStatus `json:"backends"`
+}
+
+// BackendStatus is the /status view of a single backend.
+type BackendStatus struct {
+	Addr    string `json:"addr"`
+	Healthy bool   `json:"healthy"`
+	Conns   int64  `json:"conns"`
+}
+
+// Status reports the health of every route's backend pool, for /status.
+func (r *Router) Status() []RouteStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]RouteStatus, 0, len(r.routes))
+	for _, rt := range r.routes {
+		backends := make([]BackendStatus, 0, len(rt.backends))
+		for _, b := range rt.backends {
+			backends = append(backends, BackendStatus{
+				Addr:    b.Addr,
+				Healthy: b.isHealthy(),
+				Conns:   atomic.LoadInt64(&b.conns),
+			})
+		}
+		out = append(out, RouteStatus{
+			PathPrefix: rt.pathPrefix,
+			Host:       rt.host,
+			Balance:    rt.balance,
+			Backends:   backends,
+		})
+	}
+	return out
+}
+
+// loadRoutingConfig reads path as YAML or JSON depending on its
+// extension (YAML for .yaml/.yml, JSON otherwise).
+func loadRoutingConfig(path string) (*RoutingConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read routing config: %w", err)
+	}
+
+	var cfg RoutingConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parse yaml routing config: %w", err)
+		}
+	default:
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parse json routing config: %w", err)
+		}
+	}
+	return &cfg, nil
+}
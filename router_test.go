@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRouterRoundRobin(t *testing.T) {
+	cfgPath := writeTestConfig(t, `{"routes":[{"path_prefix":"/api","backends":["http://a","http://b"]}]}`)
+
+	r, err := NewRouter(cfgPath)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://proxy/api/things", nil)
+	rt := r.match(req)
+	if rt == nil {
+		t.Fatal("expected route to match /api prefix")
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		b := rt.pick()
+		if b == nil {
+			t.Fatal("pick returned nil backend")
+		}
+		seen[b.Addr] = true
+	}
+	if len(seen) != 2 {
+		t.Fatalf("expected round robin to visit both backends, saw %v", seen)
+	}
+}
+
+func TestRouterSkipsUnhealthyBackend(t *testing.T) {
+	cfgPath := writeTestConfig(t, `{"routes":[{"path_prefix":"/api","backends":["http://a","http://b"]}]}`)
+
+	r, err := NewRouter(cfgPath)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://proxy/api/things", nil)
+	rt := r.match(req)
+	rt.backends[0].markHealthy(false)
+
+	for i := 0; i < 4; i++ {
+		b := rt.pick()
+		if b.Addr != "http://b" {
+			t.Fatalf("expected unhealthy backend to be skipped, got %s", b.Addr)
+		}
+	}
+}
+
+func TestRouterNoMatch(t *testing.T) {
+	cfgPath := writeTestConfig(t, `{"routes":[{"path_prefix":"/api","backends":["http://a"]}]}`)
+
+	r, err := NewRouter(cfgPath)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://proxy/other", nil)
+	if rt := r.match(req); rt != nil {
+		t.Fatalf("expected no route to match /other, got %+v", rt)
+	}
+}
+
+func writeTestConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routes.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("write test config: %v", err)
+	}
+	return path
+}
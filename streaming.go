@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// isWebSocketUpgrade reports whether r is requesting a WebSocket upgrade.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// isStreamingResponse reports whether resp looks like an SSE stream or a
+// chunked response that should be piped straight through instead of
+// being buffered and fed into the block-wait logic.
+func isStreamingResponse(resp *http.Response) bool {
+	if strings.HasPrefix(strings.ToLower(resp.Header.Get("Content-Type")), "text/event-stream") {
+		return true
+	}
+	for _, enc := range resp.TransferEncoding {
+		if strings.EqualFold(enc, "chunked") {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWebSocketUpgrade dials the backend directly, forwards the
+// handshake, hijacks the client connection, and then pumps bytes
+// bidirectionally until either side closes. http.Client can't do any of
+// this since it doesn't expose hijacking. backend (nil in single-backend
+// mode) gets its health and /metrics updated the same way every other
+// forwarding path does.
+func handleWebSocketUpgrade(w http.ResponseWriter, r *http.Request, backendURL string, backend *Backend, reqID string) {
+	start := time.Now()
+	backendConn, err := dialBackend(backendURL)
+	if backend != nil {
+		backend.markHealthy(err == nil)
+	}
+	if err != nil {
+		backendErrorsTotal.WithLabelValues(r.Method, metricsPath(r)).Inc()
+		logger.Error("failed to dial backend for websocket upgrade", "request_id", reqID, "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer backendConn.Close()
+
+	// r.Write would otherwise send the original client Host header; the
+	// handshake needs the backend's own, matching how the proxied HTTP
+	// and long-poll paths build their requests against backendURL.
+	originalHost := r.Host
+	if u, err := url.Parse(backendURL); err == nil {
+		r.Host = u.Host
+	}
+	err = r.Write(backendConn)
+	r.Host = originalHost
+	if err != nil {
+		if backend != nil {
+			backend.markHealthy(false)
+		}
+		backendErrorsTotal.WithLabelValues(r.Method, metricsPath(r)).Inc()
+		logger.Error("failed to forward websocket handshake", "request_id", reqID, "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		logger.Error("response writer does not support hijacking", "request_id", reqID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		logger.Error("failed to hijack client connection", "request_id", reqID, "error", err)
+		return
+	}
+	defer clientConn.Close()
+
+	if buffered := clientBuf.Reader.Buffered(); buffered > 0 {
+		if _, err := io.CopyN(backendConn, clientBuf.Reader, int64(buffered)); err != nil {
+			logger.Error("failed to flush buffered client bytes", "request_id", reqID, "error", err)
+			return
+		}
+	}
+
+	requestsTotal.WithLabelValues(r.Method, metricsPath(r), "websocket").Inc()
+	logRequest(r, reqID, "", "websocket", 0, time.Since(start), http.StatusSwitchingProtocols)
+
+	pumpBidirectional(clientConn, backendConn)
+}
+
+// dialBackend opens a raw connection to backendURL's host, using TLS
+// when the scheme calls for it.
+func dialBackend(backendURL string) (net.Conn, error) {
+	u, err := url.Parse(backendURL)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" || u.Scheme == "wss" {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	if u.Scheme == "https" || u.Scheme == "wss" {
+		return tls.Dial("tcp", addr, &tls.Config{ServerName: u.Hostname()})
+	}
+	return net.Dial("tcp", addr)
+}
+
+// pumpBidirectional copies bytes between a and b until one side closes.
+func pumpBidirectional(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	pump := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go pump(b, a)
+	go pump(a, b)
+	<-done
+}
+
+// handleStreamingResponse pipes resp straight through to w, flushing
+// after every read so SSE/chunked backends stream live instead of being
+// buffered until EOF.
+func handleStreamingResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, reqID string, backendLatency time.Duration) {
+	defer resp.Body.Close()
+	copyHeaders(w.Header(), resp.Header)
+	w.Header().Set("X-Block-St", "stream")
+	w.WriteHeader(resp.StatusCode)
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 4096)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				logger.Error("failed to write streaming response", "request_id", reqID, "error", werr)
+				break
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				logger.Error("streaming response ended", "request_id", reqID, "error", err)
+			}
+			break
+		}
+	}
+
+	requestsTotal.WithLabelValues(r.Method, metricsPath(r), "stream").Inc()
+	logRequest(r, reqID, strings.TrimSpace(resp.Header.Get("ETag")), "stream", 0, backendLatency, resp.StatusCode)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://proxy/ws", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	if !isWebSocketUpgrade(req) {
+		t.Fatal("expected upgrade request to be detected")
+	}
+
+	plain, _ := http.NewRequest(http.MethodGet, "http://proxy/ws", nil)
+	if isWebSocketUpgrade(plain) {
+		t.Fatal("expected plain request not to be detected as upgrade")
+	}
+}
+
+func TestIsStreamingResponse(t *testing.T) {
+	sse := &http.Response{Header: http.Header{"Content-Type": []string{"text/event-stream"}}}
+	if !isStreamingResponse(sse) {
+		t.Fatal("expected SSE content type to be detected as streaming")
+	}
+
+	chunked := &http.Response{Header: http.Header{}, TransferEncoding: []string{"chunked"}}
+	if !isStreamingResponse(chunked) {
+		t.Fatal("expected chunked transfer encoding to be detected as streaming")
+	}
+
+	plain := &http.Response{Header: http.Header{"Content-Type": []string{"application/json"}}}
+	if isStreamingResponse(plain) {
+		t.Fatal("expected plain JSON response not to be detected as streaming")
+	}
+}
+
+// TestHandleWebSocketUpgradePumpsBidirectionally runs a fake backend TCP
+// listener that echoes back whatever it receives after the handshake,
+// and checks that handleWebSocketUpgrade relays bytes both ways and
+// that the backend connection closes once the client hangs up.
+func TestHandleWebSocketUpgradePumpsBidirectionally(t *testing.T) {
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer backendLn.Close()
+
+	backendClosed := make(chan struct{})
+	go func() {
+		defer close(backendClosed)
+		conn, err := backendLn.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.Copy(conn, br) // echo everything after the handshake
+	}()
+
+	backendURL := "http://" + backendLn.Addr().String() + "/ws"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocketUpgrade(w, r, backendURL, nil, "test-request-id")
+	}))
+	defer srv.Close()
+
+	clientConn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer clientConn.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	if err := req.Write(clientConn); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+
+	payload := []byte("hello-websocket")
+	if _, err := clientConn.Write(payload); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+
+	echoed := make([]byte, len(payload))
+	clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(clientConn, echoed); err != nil {
+		t.Fatalf("expected echoed payload, got error: %v", err)
+	}
+	if string(echoed) != string(payload) {
+		t.Fatalf("expected echoed payload %q, got %q", payload, echoed)
+	}
+
+	clientConn.Close()
+
+	select {
+	case <-backendClosed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected backend connection to close once the client hung up")
+	}
+}